@@ -6,10 +6,21 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/millerlogic/lazymove"
+	"github.com/millerlogic/lazymove/filter"
 )
 
+// stringList collects repeatable flags like --include and --exclude.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func run() error {
 	m := &lazymove.Mover{
 		Timeout:    lazymove.DefaultTimeout,
@@ -20,6 +31,20 @@ func run() error {
 	flag.DurationVar(&m.Timeout, "timeout", m.Timeout, "How often to look for files to move")
 	flag.DurationVar(&m.MinFileAge, "minFileAge", m.MinFileAge, "Minimum age to move files")
 	flag.DurationVar(&m.MinDirAge, "minDirAge", m.MinDirAge, "Minimum age to remove empty dirs")
+	flag.IntVar(&m.Parallelism, "parallelism", 1, "How many files to move concurrently")
+	flag.Int64Var(&m.BandwidthLimit, "bandwidthLimit", 0, "Max copy throughput in bytes/sec, 0 for unlimited")
+	flag.BoolVar(&m.Watch, "watch", false, "Move files shortly after activity settles, instead of polling every timeout")
+
+	var includes, excludes stringList
+	flag.Var(&includes, "include", "Glob pattern of paths to include, relative to SourceDir (repeatable)")
+	flag.Var(&excludes, "exclude", "Glob pattern of paths to exclude, relative to SourceDir (repeatable)")
+	var minSize, maxSize int64
+	flag.Int64Var(&minSize, "min-size", 0, "Minimum file size to move, in bytes")
+	flag.Int64Var(&maxSize, "max-size", 0, "Maximum file size to move, in bytes")
+	var filterFrom string
+	flag.StringVar(&filterFrom, "filter-from", "", "Read --include/--exclude patterns from this file")
+	var onConflict string
+	flag.StringVar(&onConflict, "onConflict", "overwrite", "What to do when the destination already exists: overwrite, skip, rename, newerWins, or fail")
 
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [Options...] <SourceDir> <DestDir>\nOptions:\n", os.Args[0])
@@ -35,6 +60,43 @@ func run() error {
 	m.SourceDir = flag.Arg(0)
 	m.DestDir = flag.Arg(1)
 
+	switch onConflict {
+	case "overwrite":
+		m.OnConflict = lazymove.ConflictOverwrite
+	case "skip":
+		m.OnConflict = lazymove.ConflictSkip
+	case "rename":
+		m.OnConflict = lazymove.ConflictRename
+	case "newerWins":
+		m.OnConflict = lazymove.ConflictNewerWins
+	case "fail":
+		m.OnConflict = lazymove.ConflictFail
+	default:
+		return fmt.Errorf("invalid -onConflict value: %s", onConflict)
+	}
+
+	// A Filter is always installed so any .lazymoveignore files under
+	// SourceDir are honored even without --include/--exclude flags.
+	f := &filter.Filter{MinSize: minSize, MaxSize: maxSize}
+	for _, p := range includes {
+		f.AddInclude(p)
+	}
+	for _, p := range excludes {
+		f.AddExclude(p)
+	}
+	if filterFrom != "" {
+		file, err := os.Open(filterFrom)
+		if err != nil {
+			return err
+		}
+		err = f.AddFilterFile(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("while reading %s: %v", filterFrom, err)
+		}
+	}
+	m.Filter = f
+
 	return m.Run(context.Background())
 }
 
@@ -0,0 +1,76 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterSizeBounds(t *testing.T) {
+	f := &Filter{MinSize: 10, MaxSize: 100}
+	if f.Allowed("a.txt", 5, false) {
+		t.Errorf("expected 5-byte file to be rejected by MinSize")
+	}
+	if f.Allowed("a.txt", 200, false) {
+		t.Errorf("expected 200-byte file to be rejected by MaxSize")
+	}
+	if !f.Allowed("a.txt", 50, false) {
+		t.Errorf("expected 50-byte file to be allowed")
+	}
+	if !f.Allowed("adir", 0, true) {
+		t.Errorf("size bounds should not apply to directories")
+	}
+}
+
+func TestFilterIncludeExclude(t *testing.T) {
+	f := &Filter{}
+	f.AddExclude("*.tmp")
+	f.AddExclude("**/Thumbs.db")
+	if f.Allowed("a/b/file.tmp", 1, false) {
+		t.Errorf("expected *.tmp to be excluded")
+	}
+	if f.Allowed("a/b/Thumbs.db", 1, false) {
+		t.Errorf("expected **/Thumbs.db to be excluded")
+	}
+	if !f.Allowed("a/b/file.txt", 1, false) {
+		t.Errorf("expected unrelated file to be allowed")
+	}
+
+	f.AddInclude("a/b/file.tmp")
+	if !f.Allowed("a/b/file.tmp", 1, false) {
+		t.Errorf("expected later --include to override earlier --exclude")
+	}
+}
+
+func TestFilterIgnoreFile(t *testing.T) {
+	f := &Filter{}
+	err := f.LoadIgnoreFile("sub", strings.NewReader(strings.Join([]string{
+		"# comment",
+		"*.log",
+		"/anchored.txt",
+		"builddir/",
+		"!keep.log",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path    string
+		isDir   bool
+		allowed bool
+	}{
+		{"sub/a.log", false, false},
+		{"sub/deep/b.log", false, false},
+		{"sub/keep.log", false, true},
+		{"sub/anchored.txt", false, false},
+		{"sub/deep/anchored.txt", false, true}, // anchored to sub, not sub/deep
+		{"sub/builddir", true, false},
+		{"sub/builddir", false, true}, // dirOnly pattern shouldn't match a file
+		{"other/a.log", false, true},  // outside the ignore file's dir
+	}
+	for _, c := range cases {
+		if got := f.Allowed(c.path, 1, c.isDir); got != c.allowed {
+			t.Errorf("Allowed(%q, isDir=%v) = %v, want %v", c.path, c.isDir, got, c.allowed)
+		}
+	}
+}
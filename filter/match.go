@@ -0,0 +1,120 @@
+package filter
+
+import (
+	"path"
+	"strings"
+)
+
+func newGlobRule(pattern string, exclude bool) globRule {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	return globRule{
+		pattern:  pattern,
+		segments: strings.Split(pattern, "/"),
+		anchored: anchored,
+		exclude:  exclude,
+	}
+}
+
+func (r globRule) matches(relpath string) bool {
+	return matchAt(r.segments, relpath, r.anchored)
+}
+
+// ignoreRule is one parsed line from a .lazymoveignore file.
+type ignoreRule struct {
+	dir      string // directory (slash-separated, relative to root) the file was found in
+	segments []string
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+// newIgnoreRule parses one line of a .lazymoveignore found in dir, using
+// gitignore semantics: "!" negates, a leading "/" or any non-trailing
+// "/" anchors to dir, a trailing "/" restricts the match to directories,
+// and "**" matches zero or more path segments. Blank lines and comments
+// (lines starting with "#") report ok=false.
+func newIgnoreRule(dir, line string) (rule ignoreRule, ok bool) {
+	line = strings.TrimRight(line, " ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule.dir = dir
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasPrefix(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		// A pattern with a slash anywhere but the end is anchored to
+		// its directory, per gitignore rules.
+		rule.anchored = true
+	}
+	rule.segments = strings.Split(line, "/")
+	return rule, true
+}
+
+func (r ignoreRule) matches(relpath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel := relpath
+	if r.dir != "" {
+		if rel != r.dir && !strings.HasPrefix(rel, r.dir+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, r.dir), "/")
+	}
+	return matchAt(r.segments, rel, r.anchored)
+}
+
+// matchAt reports whether pattern segments match relpath, anchored to
+// its start if anchored, or at any path component boundary otherwise.
+func matchAt(segments []string, relpath string, anchored bool) bool {
+	target := strings.Split(relpath, "/")
+	if anchored {
+		return matchSegments(segments, target)
+	}
+	for i := range target {
+		if matchSegments(segments, target[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches pattern segments against target path segments.
+// "**" in pattern matches zero or more target segments; any other
+// segment is matched against one target segment with path.Match, so
+// "*" and "?" and "[...]" work within a single path component.
+func matchSegments(pattern, target []string) bool {
+	if len(pattern) == 0 {
+		return len(target) == 0
+	}
+	if pattern[0] == "**" {
+		if len(pattern) == 1 {
+			return true
+		}
+		for i := 0; i <= len(target); i++ {
+			if matchSegments(pattern[1:], target[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(target) == 0 {
+		return false
+	}
+	ok, err := path.Match(pattern[0], target[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], target[1:])
+}
@@ -0,0 +1,113 @@
+package lazymove
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// File is an open file handle as returned by FS.Open and FS.Create.
+// *os.File satisfies this.
+type File interface {
+	Stat() (os.FileInfo, error)
+	Read([]byte) (int, error)
+	Write([]byte) (int, error)
+	Seek(offset int64, whence int) (int64, error)
+	Close() error
+}
+
+// FS abstracts the filesystem operations Mover needs, so Mover.SourceFS
+// and Mover.DestFS can be backed by something other than the local disk
+// (e.g. a remote store, or an in-memory filesystem for tests).
+type FS interface {
+	// Walk walks the file tree rooted at root like filepath.Walk, except
+	// it must stop and return ctx.Err() promptly once ctx is done.
+	Walk(ctx context.Context, root string, fn filepath.WalkFunc) error
+	Stat(path string) (os.FileInfo, error)
+	// Open opens path for reading, like os.Open.
+	Open(path string) (File, error)
+	// Create opens path for writing with the given flag and mode, like
+	// os.OpenFile. flag is exposed (rather than fixed like os.Create)
+	// because callers need control over O_EXCL vs resuming a partial
+	// write.
+	Create(path string, flag int, mode os.FileMode) (File, error)
+	MkdirAll(path string, mode os.FileMode) error
+	Rename(oldpath, newpath string) error
+	Remove(path string) error
+	Chtimes(path string, atime, mtime time.Time) error
+}
+
+// localFS is the default FS, backed by the local disk via os and
+// filepath.
+type localFS struct{}
+
+func (localFS) Walk(ctx context.Context, root string, fn filepath.WalkFunc) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fn(path, info, err)
+	})
+}
+
+func (localFS) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localFS) Open(path string) (File, error) {
+	return os.Open(path)
+}
+
+func (localFS) Create(path string, flag int, mode os.FileMode) (File, error) {
+	return os.OpenFile(path, flag, mode)
+}
+
+func (localFS) MkdirAll(path string, mode os.FileMode) error {
+	return os.MkdirAll(path, mode)
+}
+
+func (localFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (localFS) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localFS) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(path, atime, mtime)
+}
+
+// fsIdentity is implemented by FS backends where same Go type doesn't
+// imply same backend, e.g. two distinct SFTP hosts both represented as
+// the same client type. sameFS uses it to tell them apart.
+type fsIdentity interface {
+	// FSIdentity returns a string that's equal for two FS values if and
+	// only if they refer to the same underlying backend (so a rename
+	// fast path between them is actually valid).
+	FSIdentity() string
+}
+
+// sameFS reports whether a and b are the same backend, so operations
+// that only make sense within a single backend (like the rename fast
+// path) aren't attempted across heterogeneous (or merely same-typed but
+// distinct, e.g. two different remote hosts) FS implementations.
+func sameFS(a, b FS) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	ai, aok := a.(fsIdentity)
+	bi, bok := b.(fsIdentity)
+	if aok != bok {
+		return false
+	}
+	if !aok {
+		// Neither side distinguishes instances (e.g. localFS: this
+		// process only ever sees one local disk), so same type is
+		// enough, as before.
+		return true
+	}
+	return ai.FSIdentity() == bi.FSIdentity()
+}
@@ -1,16 +1,25 @@
 package lazymove
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/millerlogic/lazymove/filter"
 )
 
 const (
@@ -22,6 +31,73 @@ const (
 	DefaultMinDirAge = time.Hour
 )
 
+// HashAlgorithm selects how Mover.VerifyHash checks copied file contents.
+type HashAlgorithm int
+
+const (
+	// HashNone disables hash verification of copied files.
+	HashNone HashAlgorithm = iota
+	// HashCRC32 verifies copies using CRC-32 (IEEE polynomial).
+	HashCRC32
+	// HashSHA256 verifies copies using SHA-256.
+	HashSHA256
+)
+
+func newHasher(algo HashAlgorithm) hash.Hash {
+	switch algo {
+	case HashCRC32:
+		return crc32.NewIEEE()
+	case HashSHA256:
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// HashMismatchError is returned through Mover.ErrorFunc when a copied
+// file's destination hash does not match the source, so ErrorFunc can
+// distinguish integrity failures from ordinary I/O failures.
+type HashMismatchError struct {
+	Path string // the destination path that failed verification
+}
+
+func (err *HashMismatchError) Error() string {
+	return "hash mismatch verifying " + err.Path
+}
+
+// ConflictAction selects how Mover.OnConflict and Mover.ConflictFunc
+// resolve a destination file that already exists.
+type ConflictAction int
+
+const (
+	// ConflictOverwrite silently replaces the destination, as always
+	// happened before OnConflict existed.
+	ConflictOverwrite ConflictAction = iota
+	// ConflictSkip leaves both the source and destination untouched,
+	// logging that the file was skipped.
+	ConflictSkip
+	// ConflictRename moves the source to the destination's basename
+	// with ".1", ".2", etc. appended until a free name is found.
+	ConflictRename
+	// ConflictNewerWins overwrites the destination only if the
+	// source's modification time is more recent; otherwise it's
+	// treated like ConflictSkip.
+	ConflictNewerWins
+	// ConflictFail reports a *ConflictError through ErrorFunc instead
+	// of touching either file.
+	ConflictFail
+)
+
+// ConflictError is returned through Mover.ErrorFunc when Mover.OnConflict
+// (or Mover.ConflictFunc) is ConflictFail and the destination exists.
+type ConflictError struct {
+	Path string // the destination path that already existed
+}
+
+func (err *ConflictError) Error() string {
+	return "destination already exists: " + err.Path
+}
+
 // DefaultErrorFunc is the default function for Mover.ErrorFunc,
 // it logs to log.Printf and returns resume=true.
 func DefaultErrorFunc(m *Mover, err error) (resume bool) {
@@ -43,11 +119,58 @@ func DefaultErrorFunc(m *Mover, err error) (resume bool) {
 type Mover struct {
 	SourceDir  string
 	DestDir    string
-	Timeout    time.Duration                     // default is DefaultTimeout
-	MinFileAge time.Duration                     // default is DefaultMinFileAge
-	MinDirAge  time.Duration                     // default is DefaultMinDirAge
-	ErrorFunc  func(*Mover, error) (resume bool) // default is DefaultErrorFunc
-	running    bool
+	Timeout    time.Duration // default is DefaultTimeout
+	MinFileAge time.Duration // default is DefaultMinFileAge
+	MinDirAge  time.Duration // default is DefaultMinDirAge
+	// ErrorFunc may be called concurrently from multiple goroutines when
+	// Parallelism > 1, one per worker hitting an error at the same
+	// time; it must synchronize any state it touches itself. Default is
+	// DefaultErrorFunc.
+	ErrorFunc func(*Mover, error) (resume bool)
+	// VerifyHash hashes copied files and re-reads the destination to
+	// confirm the bytes on disk match before removing the source.
+	// Only applies to the cross-device copy path; same-filesystem
+	// renames are already atomic. Default is HashNone.
+	VerifyHash HashAlgorithm
+	// Resume continues a prior aborted copy from a leftover partial
+	// file instead of truncating and starting over.
+	Resume bool
+	// SourceFS is the filesystem SourceDir is read from.
+	// Default is the local disk.
+	SourceFS FS
+	// DestFS is the filesystem DestDir is written to.
+	// Default is the local disk.
+	DestFS FS
+	// Parallelism is how many files are moved concurrently.
+	// Default is 1, i.e. one file at a time as before.
+	Parallelism int
+	// BandwidthLimit caps total copy throughput, in bytes/sec, shared
+	// across all of Parallelism's workers. Zero means unlimited.
+	BandwidthLimit int64
+	// ProgressFunc, if set, is called periodically while a file is
+	// being copied (not for the same-filesystem rename fast path). Like
+	// ErrorFunc, it may be called concurrently from multiple workers
+	// when Parallelism > 1, each reporting progress for its own file;
+	// it must synchronize any state it touches itself.
+	ProgressFunc func(path string, bytesCopied, bytesTotal int64)
+	// Watch schedules an iteration shortly after SourceDir activity
+	// settles, using a recursive filesystem watch, instead of waiting
+	// a fixed Timeout. It only applies when SourceFS is the local
+	// disk; it otherwise falls back to polling, same as when a watch
+	// can't be established at all (e.g. no inotify support, or the
+	// watch descriptor count is exhausted).
+	Watch bool
+	// Filter, if set, is consulted for every file and directory found
+	// under SourceDir; paths it rejects are skipped for both moving
+	// and empty-dir removal. Default is nil, i.e. no filtering.
+	Filter *filter.Filter
+	// OnConflict picks what happens when a destination file already
+	// exists. Default is ConflictOverwrite, matching prior behavior.
+	OnConflict ConflictAction
+	// ConflictFunc, if set, overrides OnConflict with custom logic,
+	// given the source and destination file info.
+	ConflictFunc func(src, dst os.FileInfo) ConflictAction
+	running      bool
 }
 
 // Run the mover.
@@ -78,7 +201,27 @@ func (m *Mover) Run(ctx context.Context) error {
 	if m.ErrorFunc == nil {
 		m.ErrorFunc = DefaultErrorFunc
 	}
+	if m.SourceFS == nil {
+		m.SourceFS = localFS{}
+	}
+	if m.DestFS == nil {
+		m.DestFS = localFS{}
+	}
+	if m.Parallelism <= 0 {
+		m.Parallelism = 1
+	}
+
+	if m.Watch {
+		if _, ok := m.SourceFS.(localFS); ok {
+			return m.runWatch(ctx)
+		}
+		log.Printf("Watch: SourceFS is not the local disk, falling back to polling")
+	}
+	return m.runPoll(ctx)
+}
 
+// runPoll is the default Run loop: it runs an iteration every Timeout.
+func (m *Mover) runPoll(ctx context.Context) error {
 	for {
 		select {
 		case <-ctx.Done():
@@ -95,30 +238,53 @@ func (m *Mover) Run(ctx context.Context) error {
 	}
 }
 
+// fileEnt is a file or dir found by runIter's SourceDir walk.
+type fileEnt struct {
+	info os.FileInfo
+	path string
+}
+
 // Do a single iteration.
 func (m *Mover) runIter(ctx context.Context) error {
 	dirsBefore := time.Now().Add(-m.MinDirAge)
 	filesBefore := time.Now().Add(-m.MinFileAge)
-	type ent struct {
-		info os.FileInfo
-		path string
-	}
-	var dirs []ent
-	var files []ent
+	var dirs []fileEnt
+	var files []fileEnt
 	isFirst := true
-	err := filepath.Walk(m.SourceDir, func(path string, info os.FileInfo, err error) error {
-		if isFirst {
-			// Ignore the dir itself, don't want to delete the sourceDir.
-			isFirst = false
-			return nil
-		}
+	err := m.SourceFS.Walk(ctx, m.SourceDir, func(path string, info os.FileInfo, err error) error {
+		relpath := filepath.ToSlash(strings.TrimPrefix(strings.TrimPrefix(path, m.SourceDir), string(filepath.Separator)))
 		if info.IsDir() {
+			if m.Filter != nil {
+				// Load (or reload) this dir's .lazymoveignore before
+				// isFirst returns below, so one placed directly in
+				// SourceDir isn't skipped along with the dir itself.
+				// LoadIgnoreFile replaces this dir's prior rules rather
+				// than accumulating them, since runIter runs forever.
+				if igf, igerr := m.SourceFS.Open(filepath.Join(path, filter.IgnoreFileName)); igerr == nil {
+					lerr := m.Filter.LoadIgnoreFile(relpath, igf)
+					igf.Close()
+					if lerr != nil {
+						log.Printf("Filter: error parsing %s: %v", filepath.Join(path, filter.IgnoreFileName), lerr)
+					}
+				}
+			}
+			if isFirst {
+				// Ignore the dir itself, don't want to delete the sourceDir.
+				isFirst = false
+				return nil
+			}
+			if m.Filter != nil && !m.Filter.Allowed(relpath, 0, true) {
+				return filepath.SkipDir
+			}
 			if info.ModTime().Before(dirsBefore) {
-				dirs = append(dirs, ent{info, path})
+				dirs = append(dirs, fileEnt{info, path})
 			}
 		} else {
+			if m.Filter != nil && !m.Filter.Allowed(relpath, info.Size(), false) {
+				return nil
+			}
 			if info.ModTime().Before(filesBefore) {
-				files = append(files, ent{info, path})
+				files = append(files, fileEnt{info, path})
 			}
 		}
 		return nil
@@ -133,79 +299,353 @@ func (m *Mover) runIter(ctx context.Context) error {
 		return nil
 	}
 
-	// Move these old files.
+	// Move these old files, up to Parallelism at a time, sharing one
+	// bandwidth limiter and stopping the rest as soon as ErrorFunc
+	// says to abort.
+	iterCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var limiter *rateLimiter
+	if m.BandwidthLimit > 0 {
+		limiter = newRateLimiter(m.BandwidthLimit)
+	}
+	sem := make(chan struct{}, m.Parallelism)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var fatal error
 	for _, fe := range files {
-		subpath := strings.TrimPrefix(fe.path, m.SourceDir)
-		newpath := filepath.Join(m.DestDir, subpath)
-		err = func() error { // Move the file:
-			err := os.MkdirAll(filepath.Dir(newpath), 0751)
-			if err != nil {
-				return err
-			}
-			//fmode := 0640
-			fmode := fe.info.Mode() // use original mode
-			fout, err := os.OpenFile(newpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fmode)
+		if iterCtx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(fe fileEnt) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := m.moveFile(iterCtx, fe, limiter)
 			if err != nil {
-				return err
-			}
-			moved := false
-			defer func() {
-				fout.Close()
-				if !moved { // Clean up newpath if not fully moved.
-					os.Remove(newpath)
+				err = fmt.Errorf("while moving file to DestDir: %v", err)
+				if !m.ErrorFunc(m, err) {
+					mu.Lock()
+					if fatal == nil {
+						fatal = err
+					}
+					mu.Unlock()
+					cancel()
 				}
-			}()
-			fin, err := os.Open(fe.path)
-			if err != nil {
-				return err
 			}
-			defer fin.Close()
-			// Copy contents:
-			nwrote, err := io.Copy(fout, fin)
+		}(fe)
+	}
+	wg.Wait()
+	if fatal != nil {
+		return fatal
+	}
+
+	// Sort dirs by length, longest first:
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[j].path) < len(dirs[i].path)
+	})
+	// Now attempt to delete all these old dirs, longest paths first.
+	// Failures are not critical in case the dir is not empty.
+	for _, de := range dirs {
+		if err := m.SourceFS.Remove(de.path); err != nil {
+			log.Printf("INFO dir remove from DestDir: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// moveFile moves a single file found by runIter's walk from SourceDir to
+// DestDir, via the rename fast path or the copy/verify slow path.
+func (m *Mover) moveFile(ctx context.Context, fe fileEnt, limiter *rateLimiter) error {
+	subpath := strings.TrimPrefix(fe.path, m.SourceDir)
+	newpath := filepath.Join(m.DestDir, subpath)
+
+	newpath, skip, err := m.resolveConflict(newpath, fe.info)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+
+	err = m.DestFS.MkdirAll(filepath.Dir(newpath), 0751)
+	if err != nil {
+		return err
+	}
+	// Fast path: same filesystem, so a rename is atomic and doesn't
+	// require copying any bytes. Only attempted when source and dest
+	// share an FS implementation; a rename can't span two different
+	// backends.
+	if sameFS(m.SourceFS, m.DestFS) {
+		err = m.DestFS.Rename(fe.path, newpath)
+		if err == nil {
+			return nil
+		}
+		if !isCrossDeviceErr(err) {
+			return err
+		}
+	}
+	// Slow path: copy across filesystems, staging into a temp sibling
+	// so newpath never shows a partial file.
+	fmode := fe.info.Mode() // use original mode
+	tmppath := m.tmpPath(newpath)
+	openFlags := os.O_RDWR | os.O_CREATE | os.O_EXCL
+	if m.Resume {
+		// Allow reopening a partial file left by an aborted run.
+		openFlags = os.O_RDWR | os.O_CREATE
+	}
+	fout, err := m.DestFS.Create(tmppath, openFlags, fmode)
+	if err != nil {
+		return err
+	}
+	moved := false
+	defer func() {
+		fout.Close()
+		if !moved { // Clean up tmppath if not fully moved.
+			m.DestFS.Remove(tmppath)
+		}
+	}()
+	fin, err := m.SourceFS.Open(fe.path)
+	if err != nil {
+		return err
+	}
+	defer fin.Close()
+
+	var startOffset int64
+	if m.Resume {
+		fi, err := fout.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() > 0 && fi.Size() <= fe.info.Size() {
+			ok, err := verifyResumePrefix(fin, fout, fi.Size(), m.VerifyHash)
 			if err != nil {
 				return err
 			}
-			if nwrote != fe.info.Size() {
-				// Fail if didn't write the full expected amount.
-				// Also fail if it wrote more, as it means there's new activity.
-				return errors.New("did not write expected byte count to " + newpath)
+			if ok {
+				startOffset = fi.Size()
 			}
-			err = fout.Close()
-			if err != nil {
+		}
+		if startOffset == 0 {
+			// Can't safely resume, start over: close and reopen
+			// truncated (the interface has no Truncate, as most
+			// backends don't either).
+			if err := fout.Close(); err != nil {
 				return err
 			}
-			fout.Sync()
-			// Remove the original file:
-			err = os.Remove(fe.path)
+			fout, err = m.DestFS.Create(tmppath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, fmode)
 			if err != nil {
 				return err
 			}
-			moved = true
-			return nil
-		}()
-		if err != nil {
-			err = fmt.Errorf("while moving file to DestDir: %v", err)
-			if !m.ErrorFunc(m, err) {
-				return err
-			}
 		}
 	}
 
-	// Sort dirs by length, longest first:
-	sort.Slice(dirs, func(i, j int) bool {
-		return len(dirs[j].path) < len(dirs[i].path)
-	})
-	// Now attempt to delete all these old dirs, longest paths first.
-	// Failures are not critical in case the dir is not empty.
-	for _, de := range dirs {
-		if err := os.Remove(de.path); err != nil {
-			log.Printf("INFO dir remove from DestDir: %v", err)
+	hasher := newHasher(m.VerifyHash)
+	if hasher != nil && startOffset > 0 {
+		// Fold the already-written prefix into the hash so it
+		// reflects the whole file, not just the resumed part.
+		if _, err := fin.Seek(0, io.SeekStart); err != nil {
+			return err
 		}
+		if _, err := io.CopyN(hasher, fin, startOffset); err != nil {
+			return err
+		}
+	}
+	if _, err := fin.Seek(startOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := fout.Seek(startOffset, io.SeekStart); err != nil {
+		return err
 	}
 
+	// A canceling, bandwidth-limited reader so a slow copy notices the
+	// batch was aborted and so BandwidthLimit is enforced across all
+	// of Parallelism's workers.
+	r := io.Reader(&throttledReader{ctx: ctx, r: fin, limiter: limiter})
+	var w io.Writer = fout
+	if hasher != nil {
+		w = io.MultiWriter(fout, hasher)
+	}
+	if m.ProgressFunc != nil {
+		w = &progressWriter{w: w, path: fe.path, total: fe.info.Size(), fn: m.ProgressFunc}
+	}
+	// Copy contents:
+	nwrote, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if startOffset+nwrote != fe.info.Size() {
+		// Fail if didn't write the full expected amount.
+		// Also fail if it wrote more, as it means there's new activity.
+		return errors.New("did not write expected byte count to " + tmppath)
+	}
+	err = fout.Close()
+	if err != nil {
+		return err
+	}
+	if hasher != nil {
+		dstSum, err := hashFile(m.DestFS, tmppath, m.VerifyHash)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(hasher.Sum(nil), dstSum) {
+			return &HashMismatchError{Path: tmppath}
+		}
+	}
+	// Re-check for a conflict right before committing: the check at the
+	// top of this function ran before the copy above, which can take a
+	// long time, so something may have appeared at newpath since then.
+	newpath, skip, err = m.resolveConflict(newpath, fe.info)
+	if err != nil {
+		return err
+	}
+	if skip {
+		return nil
+	}
+	err = m.DestFS.Rename(tmppath, newpath)
+	if err != nil {
+		return err
+	}
+	moved = true
+	// Preserve the source's mtime/atime on the destination,
+	// see the os.Chtimes example, so downstream age-based
+	// decisions stay meaningful.
+	err = m.DestFS.Chtimes(newpath, fe.info.ModTime(), fe.info.ModTime())
+	if err != nil {
+		return err
+	}
+	// Remove the original file:
+	err = m.SourceFS.Remove(fe.path)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// resolveConflict checks whether newpath already exists and, if so,
+// applies ConflictFunc (or OnConflict) to decide how to proceed. It
+// returns the path that should actually be written to (ConflictRename
+// may pick a different one) and whether the move should be skipped
+// entirely.
+func (m *Mover) resolveConflict(newpath string, srcInfo os.FileInfo) (resolved string, skip bool, err error) {
+	dstInfo, statErr := m.DestFS.Stat(newpath)
+	if statErr != nil {
+		if os.IsNotExist(statErr) {
+			return newpath, false, nil // nothing at newpath yet
+		}
+		return "", false, statErr
+	}
+	action := m.OnConflict
+	if m.ConflictFunc != nil {
+		action = m.ConflictFunc(srcInfo, dstInfo)
+	}
+	switch action {
+	case ConflictSkip:
+		log.Printf("Conflict: %s already exists, skipping", newpath)
+		return "", true, nil
+	case ConflictRename:
+		candidate, err := nextAvailableName(m.DestFS, newpath)
+		if err != nil {
+			return "", false, err
+		}
+		return candidate, false, nil
+	case ConflictNewerWins:
+		if srcInfo.ModTime().After(dstInfo.ModTime()) {
+			return newpath, false, nil
+		}
+		log.Printf("Conflict: %s is not newer than %s, skipping", srcInfo.Name(), newpath)
+		return "", true, nil
+	case ConflictFail:
+		return "", false, &ConflictError{Path: newpath}
+	default: // ConflictOverwrite
+		return newpath, false, nil
+	}
+}
+
+// nextAvailableName returns path with ".1", ".2", etc. appended until it
+// finds one that doesn't exist on fs, for ConflictRename. A Stat error
+// other than not-exist aborts the search and is returned, rather than
+// being treated as "this candidate is free".
+func nextAvailableName(fs FS, path string) (string, error) {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d", path, i)
+		_, err := fs.Stat(candidate)
+		if err == nil {
+			continue
+		}
+		if os.IsNotExist(err) {
+			return candidate, nil
+		}
+		return "", err
+	}
+}
+
+// isCrossDeviceErr reports whether err is the failure os.Rename returns
+// when src and dst are on different filesystems (EXDEV), in which case
+// the caller should fall back to a copy instead of treating it as fatal.
+func isCrossDeviceErr(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// tmpPath returns the staging path used while copying newpath.
+// When Resume is enabled it is a stable name so a later run can find and
+// continue it; otherwise it's randomized, since nothing will reopen it.
+func (m *Mover) tmpPath(newpath string) string {
+	if m.Resume {
+		return newpath + ".partial"
+	}
+	return fmt.Sprintf("%s.lazymove-tmp-%d", newpath, rand.Int63())
+}
+
+// verifyResumePrefix reports whether the first n bytes already written to
+// fout match the first n bytes of fin, so it's safe to resume the copy at
+// offset n instead of starting over. It leaves both files' offsets at n.
+func verifyResumePrefix(fin, fout File, n int64, algo HashAlgorithm) (bool, error) {
+	srcSum, err := hashRange(fin, n, algo)
+	if err != nil {
+		return false, err
+	}
+	dstSum, err := hashRange(fout, n, algo)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(srcSum, dstSum), nil
+}
+
+// hashRange hashes the first n bytes read from f, starting at offset 0,
+// using algo (falling back to CRC32 if hashing is otherwise disabled,
+// since this check needs some hash regardless of Mover.VerifyHash).
+func hashRange(f File, n int64, algo HashAlgorithm) ([]byte, error) {
+	if algo == HashNone {
+		algo = HashCRC32
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	h := newHasher(algo)
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// hashFile re-opens and hashes path on fs with algo, used to verify a
+// freshly written destination file matches the hash computed while
+// copying.
+func hashFile(fs FS, path string, algo HashAlgorithm) ([]byte, error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := newHasher(algo)
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
 // MoveAbortedError is used with Mover.ErrorFunc,
 // see Mover for more info.
 // Mover is the *Mover that was aborted,
@@ -2,14 +2,21 @@ package lazymove
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
 	"time"
+
+	"github.com/millerlogic/lazymove/filter"
 )
 
-func TestMover(t *testing.T) {
+// TestMoverLocalFS is the original TestMover: a real-disk integration
+// smoke test exercising localFS and the Run polling loop over real time.
+// See TestMoverMemFS for the same file/dir-age scenarios driven through
+// an in-memory FS instead, with no /tmp and no real sleeping.
+func TestMoverLocalFS(t *testing.T) {
 	basedir, err := ioutil.TempDir("", "lazymove")
 	if err != nil {
 		t.Fatal(err)
@@ -105,6 +112,491 @@ func TestMover(t *testing.T) {
 	})
 }
 
+func TestResolveConflict(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "lazymove-conflict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basedir)
+
+	newpath := basedir + "/dst.txt"
+	ioutil.WriteFile(newpath, []byte("existing"), 0600)
+
+	m := &Mover{DestFS: localFS{}}
+
+	m.OnConflict = ConflictSkip
+	_, skip, err := m.resolveConflict(newpath, nil)
+	if err != nil || !skip {
+		t.Errorf("ConflictSkip: got skip=%v err=%v, want skip=true err=nil", skip, err)
+	}
+
+	m.OnConflict = ConflictFail
+	_, _, err = m.resolveConflict(newpath, nil)
+	if _, ok := err.(*ConflictError); !ok {
+		t.Errorf("ConflictFail: got err=%v, want *ConflictError", err)
+	}
+
+	m.OnConflict = ConflictRename
+	resolved, skip, err := m.resolveConflict(newpath, nil)
+	if err != nil || skip || resolved != newpath+".1" {
+		t.Errorf("ConflictRename: got resolved=%q skip=%v err=%v, want %q false nil", resolved, skip, err, newpath+".1")
+	}
+
+	m.OnConflict = ConflictOverwrite
+	resolved, skip, err = m.resolveConflict(newpath, nil)
+	if err != nil || skip || resolved != newpath {
+		t.Errorf("ConflictOverwrite: got resolved=%q skip=%v err=%v, want %q false nil", resolved, skip, err, newpath)
+	}
+
+	resolved, skip, err = m.resolveConflict(basedir+"/missing.txt", nil)
+	if err != nil || skip || resolved != basedir+"/missing.txt" {
+		t.Errorf("no conflict: got resolved=%q skip=%v err=%v", resolved, skip, err)
+	}
+}
+
+// TestMoverMemFS exercises the same age-gating scenarios as
+// TestMoverLocalFS, but through an in-memory FS: file/dir ages are set
+// directly via Chtimes rather than by sleeping for real time to pass,
+// and runIter is called directly instead of going through Run's Timeout
+// loop, so the whole test runs instantly with no /tmp involved.
+func TestMoverMemFS(t *testing.T) {
+	fs := newMemFS()
+
+	write := func(p, content string) {
+		if err := fs.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Create(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	touch := func(p string, age time.Duration) {
+		if err := fs.Chtimes(p, time.Time{}, time.Now().Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("/src/a/afile.txt", "A!")
+	write("/src/a/b/bfile.txt", "B!")
+	write("/src/a/b/c/cfile.txt", "C!")
+
+	const minFileAge = time.Minute
+	const minDirAge = 2 * time.Minute
+
+	m := &Mover{
+		SourceDir:  "/src",
+		DestDir:    "/dst",
+		SourceFS:   fs,
+		DestFS:     fs,
+		MinFileAge: minFileAge,
+		MinDirAge:  minDirAge,
+		// runIter is called directly below rather than through Run, so
+		// Run's usual default-filling never runs; set it here instead.
+		Parallelism: 1,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+
+	// Too new: nothing should move yet.
+	touch("/src/a/afile.txt", minFileAge/2)
+	touch("/src/a/b/bfile.txt", minFileAge/2)
+	touch("/src/a/b/c/cfile.txt", minFileAge/2)
+	if err := m.runIter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFSTest(t, fs, "/dst", map[string]bool{"a": false})
+	checkMemFSTest(t, fs, "/src", map[string]bool{
+		"a/afile.txt": true, "a/b/bfile.txt": true, "a/b/c/cfile.txt": true,
+	})
+
+	// Files old enough now, dirs not yet (they're only emptied by this
+	// same pass, so their own age doesn't matter until the next one).
+	touch("/src/a/afile.txt", 2*minFileAge)
+	touch("/src/a/b/bfile.txt", 2*minFileAge)
+	touch("/src/a/b/c/cfile.txt", 2*minFileAge)
+	touch("/src/a", minDirAge/2)
+	touch("/src/a/b", minDirAge/2)
+	touch("/src/a/b/c", minDirAge/2)
+	if err := m.runIter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFSTest(t, fs, "/dst", map[string]bool{
+		"a/afile.txt": true, "a/b/bfile.txt": true, "a/b/c/cfile.txt": true,
+	})
+	checkMemFSTest(t, fs, "/src", map[string]bool{
+		"a/afile.txt": false, "a/b/bfile.txt": false, "a/b/c/cfile.txt": false,
+		"a": true, "a/b": true, "a/b/c": true,
+	})
+
+	// Now the (already empty) dirs are old enough too.
+	touch("/src/a", 2*minDirAge)
+	touch("/src/a/b", 2*minDirAge)
+	touch("/src/a/b/c", 2*minDirAge)
+	if err := m.runIter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	checkMemFSTest(t, fs, "/src", map[string]bool{
+		"a": false, "a/b": false, "a/b/c": false, ".": true,
+	})
+}
+
+// TestMoverHashVerify confirms a slow-path copy with VerifyHash set
+// completes and lands the full, correct content at the destination.
+func TestMoverHashVerify(t *testing.T) {
+	src, dst := newMemFS(), newMemFS() // distinct FS instances force the slow copy path
+	content := []byte("hash me please, this is the file content")
+	writeMemFile(t, src, "/src/file.txt", content)
+	old := time.Now().Add(-time.Hour)
+	if err := src.Chtimes("/src/file.txt", time.Time{}, old); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mover{
+		SourceDir: "/src", DestDir: "/dst",
+		SourceFS: src, DestFS: dst,
+		VerifyHash:  HashSHA256,
+		Parallelism: 1,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+	if err := m.runIter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMemFile(t, dst, "/dst/file.txt"); string(got) != string(content) {
+		t.Errorf("dst/file.txt = %q, want %q", got, content)
+	}
+	if _, err := src.Stat("/src/file.txt"); err == nil {
+		t.Errorf("src/file.txt should have been removed after the verified copy")
+	}
+}
+
+// TestMoverResume confirms a leftover ".partial" file with a valid
+// matching prefix is continued from, rather than restarted, and that
+// the final destination content is still correct.
+func TestMoverResume(t *testing.T) {
+	src, dst := newMemFS(), newMemFS() // distinct FS instances force the slow copy path
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte('a' + i%26)
+	}
+	writeMemFile(t, src, "/src/file.txt", content)
+	old := time.Now().Add(-time.Hour)
+	if err := src.Chtimes("/src/file.txt", time.Time{}, old); err != nil {
+		t.Fatal(err)
+	}
+	// Pre-seed a partial destination matching the first 400 bytes, as a
+	// prior aborted run would have left behind.
+	writeMemFile(t, dst, "/dst/file.txt.partial", content[:400])
+
+	m := &Mover{
+		SourceDir: "/src", DestDir: "/dst",
+		SourceFS: src, DestFS: dst,
+		Resume:      true,
+		Parallelism: 1,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+	if err := m.runIter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := readMemFile(t, dst, "/dst/file.txt"); string(got) != string(content) {
+		t.Errorf("dst/file.txt wrong after resume: got %d bytes, want %d", len(got), len(content))
+	}
+	if _, err := dst.Stat("/dst/file.txt.partial"); err == nil {
+		t.Errorf("file.txt.partial should be gone once the move completes")
+	}
+}
+
+func writeMemFile(t *testing.T, fs *memFS, p string, content []byte) {
+	t.Helper()
+	if err := fs.MkdirAll(path.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readMemFile(t *testing.T, fs *memFS, p string) []byte {
+	t.Helper()
+	f, err := fs.Open(p)
+	if err != nil {
+		t.Fatalf("open %s: %v", p, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, info.Size())
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// TestMoverWatch confirms Watch mode moves a file shortly after activity
+// settles, well inside Timeout, proving the watcher (not polling) drove
+// the move. Uses real disk, since fsnotify only watches real paths.
+func TestMoverWatch(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "lazymove-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basedir)
+
+	srcdir := basedir + "/src"
+	os.Mkdir(srcdir, 0700)
+	destdir := basedir + "/dest"
+	os.Mkdir(destdir, 0700)
+
+	const settle = 150 * time.Millisecond
+	m := &Mover{
+		SourceDir:  srcdir,
+		DestDir:    destdir,
+		Watch:      true,
+		Timeout:    time.Hour, // should never fire; Watch drives the scan
+		MinFileAge: settle,
+		MinDirAge:  time.Hour,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := m.Run(ctx)
+		if err != nil && err != context.Canceled {
+			t.Errorf("Run returned: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond) // let the watcher get established
+	ioutil.WriteFile(srcdir+"/file.txt", []byte("hi"), 0600)
+
+	time.Sleep(settle + 500*time.Millisecond)
+	checkFilesTest(t, destdir, map[string]bool{"file.txt": true})
+	checkFilesTest(t, srcdir, map[string]bool{"file.txt": false})
+}
+
+// TestMoverWatchInitialBacklog confirms a file already sitting in
+// SourceDir (and already old enough) before Watch starts still gets
+// moved, rather than waiting forever for an fsnotify event that may
+// never come.
+func TestMoverWatchInitialBacklog(t *testing.T) {
+	basedir, err := ioutil.TempDir("", "lazymove-watch-backlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(basedir)
+
+	srcdir := basedir + "/src"
+	os.Mkdir(srcdir, 0700)
+	destdir := basedir + "/dest"
+	os.Mkdir(destdir, 0700)
+
+	const settle = 150 * time.Millisecond
+	old := time.Now().Add(-time.Hour)
+	ioutil.WriteFile(srcdir+"/file.txt", []byte("hi"), 0600)
+	os.Chtimes(srcdir+"/file.txt", old, old)
+
+	m := &Mover{
+		SourceDir:  srcdir,
+		DestDir:    destdir,
+		Watch:      true,
+		Timeout:    time.Hour, // should never fire; Watch drives the scan
+		MinFileAge: settle,
+		MinDirAge:  time.Hour,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		err := m.Run(ctx)
+		if err != nil && err != context.Canceled {
+			t.Errorf("Run returned: %v", err)
+		}
+	}()
+
+	// No fs activity at all after startup: if Watch only reacted to
+	// events, file.txt would never move.
+	time.Sleep(settle + 500*time.Millisecond)
+	checkFilesTest(t, destdir, map[string]bool{"file.txt": true})
+	checkFilesTest(t, srcdir, map[string]bool{"file.txt": false})
+}
+
+// TestRunIterRootIgnoreFile confirms a .lazymoveignore placed directly
+// in SourceDir is honored, and that running runIter many times doesn't
+// grow the Filter's loaded ignore rules without bound (it used to: every
+// runIter call re-appended every directory's rules, forever, since
+// runIter runs for the lifetime of the process).
+func TestRunIterRootIgnoreFile(t *testing.T) {
+	fs := newMemFS()
+
+	write := func(p, content string) {
+		if err := fs.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Create(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	old := time.Now().Add(-time.Hour)
+
+	write("/src/"+filter.IgnoreFileName, "ignored.txt\n")
+	write("/src/ignored.txt", "skip me")
+	write("/src/keep.txt", "keep me")
+	for _, p := range []string{"/src", "/src/ignored.txt", "/src/keep.txt"} {
+		if err := fs.Chtimes(p, time.Time{}, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := &Mover{
+		SourceDir:   "/src",
+		DestDir:     "/dst",
+		SourceFS:    fs,
+		DestFS:      fs,
+		Filter:      &filter.Filter{},
+		Parallelism: 1,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+
+	const iterations = 5
+	for i := 0; i < iterations; i++ {
+		if err := m.runIter(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		// Re-create keep.txt each pass (it gets moved away) so every
+		// iteration re-walks and re-loads /src/.lazymoveignore.
+		write("/src/keep.txt", "keep me")
+		if err := fs.Chtimes("/src/keep.txt", time.Time{}, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkMemFSTest(t, fs, "/src", map[string]bool{"ignored.txt": true})
+	checkMemFSTest(t, fs, "/dst", map[string]bool{"ignored.txt": false, "keep.txt": true})
+
+	if n := m.Filter.RuleCount(); n != 1 {
+		t.Errorf("after %d runIter calls, want 1 loaded ignore rule (re-loaded, not accumulated), got %d", iterations, n)
+	}
+}
+
+// TestBandwidthLimitDoesNotHang reproduces a deadlock where
+// throttledReader.Read asked waitN for a whole io.Copy buffer's worth of
+// bytes at once (tens of KB), which a BandwidthLimit set below that (any
+// realistic throttle) can never grant, since waitN's bucket never holds
+// more than BandwidthLimit. Bounded by a context deadline so a
+// regression fails instead of hanging the test suite.
+func TestBandwidthLimitDoesNotHang(t *testing.T) {
+	src, dst := newMemFS(), newMemFS() // distinct FS instances force the slow copy path
+	content := make([]byte, 5000)
+	for i := range content {
+		content[i] = 'x'
+	}
+	if err := src.MkdirAll("/src", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := src.Create("/src/file.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	old := time.Now().Add(-time.Hour)
+	if err := src.Chtimes("/src/file.txt", time.Time{}, old); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Mover{
+		SourceDir: "/src", DestDir: "/dst",
+		SourceFS: src, DestFS: dst,
+		BandwidthLimit: 2000, // well under io.Copy's ~32KB buffer
+		Parallelism:    1,
+		ErrorFunc: func(m *Mover, err error) (resume bool) {
+			t.Errorf("ErrorFunc: %v", err)
+			return false
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.runIter(ctx); err != nil {
+		t.Fatalf("runIter: %v (did BandwidthLimit hang the copy?)", err)
+	}
+
+	got, err := dst.Open("/dst/file.txt")
+	if err != nil {
+		t.Fatalf("dst/file.txt missing after move: %v", err)
+	}
+	gotData := make([]byte, len(content)+1)
+	n, _ := got.Read(gotData)
+	if n != len(content) || string(gotData[:n]) != string(content) {
+		t.Errorf("dst/file.txt content wrong: got %d bytes, want %d", n, len(content))
+	}
+}
+
+func TestSameFS(t *testing.T) {
+	if !sameFS(localFS{}, localFS{}) {
+		t.Errorf("localFS should always be sameFS as itself, there's only one local disk")
+	}
+	a, b := newMemFS(), newMemFS()
+	if sameFS(a, b) {
+		t.Errorf("two distinct memFS instances should not be sameFS, same as two different remote hosts behind the same client type")
+	}
+	if !sameFS(a, a) {
+		t.Errorf("a memFS instance should be sameFS as itself")
+	}
+}
+
+func checkMemFSTest(t *testing.T, fs *memFS, dir string, check map[string]bool) {
+	for x, shouldExist := range check {
+		p := path.Join(dir, x)
+		_, err := fs.Stat(p)
+		exists := err == nil
+		if shouldExist != exists {
+			if err == nil {
+				t.Errorf("%s exists but shouldn't", p)
+			} else {
+				t.Errorf("%s: %v", p, err)
+			}
+		}
+	}
+}
+
 func checkFilesTest(t *testing.T, dir string, check map[string]bool) error {
 	for x, shouldExist := range check {
 		p := path.Join(dir, x)
@@ -0,0 +1,317 @@
+package lazymove
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS is an in-memory FS, for tests that want to exercise Mover without
+// touching the real disk. Unlike the real disk, a memFS entry's ModTime
+// is just data set directly on it, so tests can make files "old" enough
+// to move without sleeping for real time to pass. Paths are always
+// slash-separated, regardless of GOOS.
+type memFS struct {
+	mu   sync.Mutex
+	root *memNode
+}
+
+type memNode struct {
+	name     string
+	isDir    bool
+	mode     os.FileMode
+	modTime  time.Time
+	data     []byte
+	children map[string]*memNode // nil for files
+}
+
+func newMemFS() *memFS {
+	return &memFS{root: &memNode{
+		name: "/", isDir: true, mode: 0755 | os.ModeDir,
+		modTime: time.Now(), children: map[string]*memNode{},
+	}}
+}
+
+// FSIdentity makes two distinct *memFS values never compare sameFS, even
+// though they share a Go type, the same way two different remote hosts
+// behind the same client type would.
+func (fs *memFS) FSIdentity() string {
+	return fmt.Sprintf("%p", fs)
+}
+
+func memParts(p string) []string {
+	p = strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if p == "" || p == "." {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+func (fs *memFS) lookup(p string) (*memNode, error) {
+	n := fs.root
+	for _, part := range memParts(p) {
+		if !n.isDir {
+			return nil, os.ErrNotExist
+		}
+		child, ok := n.children[part]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// lookupParent returns the parent dir of p (creating intermediate dirs
+// along the way if mkdirs) and p's base name.
+func (fs *memFS) lookupParent(p string, mkdirs bool) (*memNode, string, error) {
+	parts := memParts(p)
+	if len(parts) == 0 {
+		return nil, "", fmt.Errorf("memFS: %q is the root", p)
+	}
+	n := fs.root
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := n.children[part]
+		if !ok {
+			if !mkdirs {
+				return nil, "", os.ErrNotExist
+			}
+			child = &memNode{name: part, isDir: true, mode: 0755 | os.ModeDir, modTime: time.Now(), children: map[string]*memNode{}}
+			n.children[part] = child
+		} else if !child.isDir {
+			return nil, "", fmt.Errorf("memFS: %s is not a directory", part)
+		}
+		n = child
+	}
+	return n, parts[len(parts)-1], nil
+}
+
+// Walk does not hold fs.mu while calling fn, since fn (e.g. runIter's
+// callback, which opens .lazymoveignore files) legitimately calls back
+// into fs and fs.mu isn't reentrant.
+func (fs *memFS) Walk(ctx context.Context, root string, fn filepath.WalkFunc) error {
+	fs.mu.Lock()
+	start, err := fs.lookup(root)
+	fs.mu.Unlock()
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	var walk func(p string, n *memNode) error
+	walk = func(p string, n *memNode) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(p, memFileInfo{n}, nil); err != nil {
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+		if !n.isDir {
+			return nil
+		}
+		fs.mu.Lock()
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		children := make([]*memNode, len(names))
+		for i, name := range names {
+			children[i] = n.children[name]
+		}
+		fs.mu.Unlock()
+		for i, name := range names {
+			if err := walk(path.Join(p, name), children[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(root, start)
+}
+
+func (fs *memFS) Stat(p string) (os.FileInfo, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	return memFileInfo{n}, nil
+}
+
+func (fs *memFS) Open(p string) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	if n.isDir {
+		return nil, &os.PathError{Op: "open", Path: p, Err: fmt.Errorf("is a directory")}
+	}
+	return &memFile{fs: fs, node: n}, nil
+}
+
+func (fs *memFS) Create(p string, flag int, mode os.FileMode) (File, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parent, name, err := fs.lookupParent(p, false)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+	n, exists := parent.children[name]
+	if exists {
+		if flag&os.O_EXCL != 0 {
+			return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrExist}
+		}
+		if flag&os.O_TRUNC != 0 {
+			n.data = nil
+		}
+	} else {
+		n = &memNode{name: name, mode: mode}
+		parent.children[name] = n
+	}
+	n.modTime = time.Now()
+	return &memFile{fs: fs, node: n}, nil
+}
+
+func (fs *memFS) MkdirAll(p string, mode os.FileMode) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parent, name, err := fs.lookupParent(p, true)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+	if n, ok := parent.children[name]; ok {
+		if !n.isDir {
+			return &os.PathError{Op: "mkdir", Path: p, Err: fmt.Errorf("not a directory")}
+		}
+		return nil
+	}
+	parent.children[name] = &memNode{name: name, isDir: true, mode: mode | os.ModeDir, modTime: time.Now(), children: map[string]*memNode{}}
+	return nil
+}
+
+func (fs *memFS) Rename(oldpath, newpath string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	oldParent, oldName, err := fs.lookupParent(oldpath, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: err}
+	}
+	n, ok := oldParent.children[oldName]
+	if !ok {
+		return &os.PathError{Op: "rename", Path: oldpath, Err: os.ErrNotExist}
+	}
+	newParent, newName, err := fs.lookupParent(newpath, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newpath, Err: err}
+	}
+	delete(oldParent.children, oldName)
+	n.name = newName
+	newParent.children[newName] = n
+	return nil
+}
+
+func (fs *memFS) Remove(p string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	parent, name, err := fs.lookupParent(p, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: p, Err: err}
+	}
+	n, ok := parent.children[name]
+	if !ok {
+		return &os.PathError{Op: "remove", Path: p, Err: os.ErrNotExist}
+	}
+	if n.isDir && len(n.children) > 0 {
+		return &os.PathError{Op: "remove", Path: p, Err: fmt.Errorf("directory not empty")}
+	}
+	delete(parent.children, name)
+	return nil
+}
+
+func (fs *memFS) Chtimes(p string, atime, mtime time.Time) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	n, err := fs.lookup(p)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: p, Err: err}
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// memFileInfo adapts a memNode to os.FileInfo.
+type memFileInfo struct{ n *memNode }
+
+func (i memFileInfo) Name() string       { return i.n.name }
+func (i memFileInfo) Size() int64        { return int64(len(i.n.data)) }
+func (i memFileInfo) Mode() os.FileMode  { return i.n.mode }
+func (i memFileInfo) ModTime() time.Time { return i.n.modTime }
+func (i memFileInfo) IsDir() bool        { return i.n.isDir }
+func (i memFileInfo) Sys() interface{}   { return nil }
+
+// memFile is an open handle onto a memNode, implementing File.
+type memFile struct {
+	fs     *memFS
+	node   *memNode
+	offset int64
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return memFileInfo{f.node}, nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.offset >= int64(len(f.node.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	end := f.offset + int64(len(p))
+	if end > int64(len(f.node.data)) {
+		grown := make([]byte, end)
+		copy(grown, f.node.data)
+		f.node.data = grown
+	}
+	n := copy(f.node.data[f.offset:end], p)
+	f.offset += int64(n)
+	f.node.modTime = time.Now()
+	return n, nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch whence {
+	case 0: // io.SeekStart
+		f.offset = offset
+	case 1: // io.SeekCurrent
+		f.offset += offset
+	case 2: // io.SeekEnd
+		f.offset = int64(len(f.node.data)) + offset
+	}
+	return f.offset, nil
+}
+
+func (f *memFile) Close() error { return nil }
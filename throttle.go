@@ -0,0 +1,93 @@
+package lazymove
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a shared token-bucket limiter implementing
+// Mover.BandwidthLimit across Parallelism's concurrent workers.
+type rateLimiter struct {
+	bytesPerSec int64
+
+	mu     sync.Mutex
+	tokens int64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{bytesPerSec: bytesPerSec, tokens: bytesPerSec, last: time.Now()}
+}
+
+// waitN blocks until n bytes' worth of bandwidth is available, or ctx is
+// done.
+func (rl *rateLimiter) waitN(ctx context.Context, n int64) error {
+	for {
+		rl.mu.Lock()
+		now := time.Now()
+		rl.tokens += int64(now.Sub(rl.last).Seconds() * float64(rl.bytesPerSec))
+		rl.last = now
+		if rl.tokens > rl.bytesPerSec {
+			rl.tokens = rl.bytesPerSec // cap burst to one second's worth
+		}
+		if rl.tokens >= n {
+			rl.tokens -= n
+			rl.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration(float64(n-rl.tokens) / float64(rl.bytesPerSec) * float64(time.Second))
+		rl.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// throttledReader wraps r so reads stop promptly once ctx is done, and
+// (if limiter is non-nil) are paced to limiter's shared bandwidth budget.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if t.limiter != nil {
+		// Never ask for more than the bucket can ever hold: waitN would
+		// otherwise block forever once p is larger than bytesPerSec (the
+		// callers' io.Copy buffer routinely is). Reading in smaller
+		// chunks just paces the copy instead of freezing it.
+		if max := t.limiter.bytesPerSec; int64(len(p)) > max {
+			p = p[:max]
+		}
+		if err := t.limiter.waitN(t.ctx, int64(len(p))); err != nil {
+			return 0, err
+		}
+	}
+	return t.r.Read(p)
+}
+
+// progressWriter wraps w, calling fn after every write with the running
+// total of bytes copied so far.
+type progressWriter struct {
+	w     io.Writer
+	path  string
+	total int64
+	fn    func(path string, bytesCopied, bytesTotal int64)
+
+	copied int64
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.copied += int64(n)
+	p.fn(p.path, p.copied, p.total)
+	return n, err
+}
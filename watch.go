@@ -0,0 +1,94 @@
+package lazymove
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// runWatch is the Mover.Watch Run loop: it schedules a runIter shortly
+// after SourceDir activity settles, using a recursive filesystem watch,
+// instead of waiting a fixed Timeout. It falls back to runPoll if a
+// watcher can't be established at all.
+func (m *Mover) runWatch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Watch: could not create watcher, falling back to polling: %v", err)
+		return m.runPoll(ctx)
+	}
+	defer watcher.Close()
+
+	if err := addRecursive(watcher, m.SourceDir); err != nil {
+		log.Printf("Watch: could not watch %s, falling back to polling: %v", m.SourceDir, err)
+		return m.runPoll(ctx)
+	}
+
+	// settle fires MinFileAge after the last event, so a burst of
+	// writes to one file only triggers a single scan once things have
+	// been quiet for MinFileAge, rather than the worst-case
+	// Timeout+MinFileAge latency of pure polling. It starts armed so a
+	// backlog already sitting in SourceDir when Watch starts gets an
+	// initial scan too, instead of waiting on fs activity that may
+	// never come: Watch replaces polling, so it must guarantee the same
+	// eventual progress, not just progress after the next event.
+	settle := time.NewTimer(m.MinFileAge)
+	settleArmed := true
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return m.runPoll(ctx)
+			}
+			if ev.Has(fsnotify.Create) {
+				if fi, statErr := os.Stat(ev.Name); statErr == nil && fi.IsDir() {
+					// Watch dirs created after startup too.
+					if err := addRecursive(watcher, ev.Name); err != nil {
+						log.Printf("Watch: could not watch new dir %s: %v", ev.Name, err)
+					}
+				}
+			}
+			if settleArmed && !settle.Stop() {
+				<-settle.C
+			}
+			settle.Reset(m.MinFileAge)
+			settleArmed = true
+
+		case <-settle.C:
+			settleArmed = false
+			err := m.runIter(ctx)
+			if err != nil {
+				err = &MoveAbortedError{m, err}
+				if !m.ErrorFunc(m, err) {
+					return err
+				}
+			}
+
+		case watchErr, ok := <-watcher.Errors:
+			if !ok {
+				return m.runPoll(ctx)
+			}
+			log.Printf("Watch: watcher error: %v", watchErr)
+		}
+	}
+}
+
+// addRecursive adds root and all its subdirectories to watcher.
+func addRecursive(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}